@@ -30,10 +30,25 @@ type Bucket struct {
 	filled  []bool   //False if cell is empty. Only read `t.entries[i]` if `t.filled[i]==true`
 }
 
+// RehashFunc recomputes the pair of candidate buckets for `data` against a
+// table that now has `numBuckets` buckets. Callers supply this so Table can
+// grow itself without knowing how Data maps to bucket indices.
+type RehashFunc func(data Comparable, numBuckets int) (bucket1 int, bucket2 int)
+
+// maxKicks bounds how many displacements Insert will attempt before
+// declaring eviction a failure and falling back to a resize (or, if no
+// Rehash hook is set, giving up).
+const maxKicks = 500
+
 type Table struct {
 	numBuckets int       // Number of buckets
 	depth      int       // Capacity of each bucket
 	buckets    []*Bucket // Data
+	size       int       // Number of live entries
+
+	// Rehash recomputes an entry's Bucket1/Bucket2 for a larger table. If
+	// nil, Insert returns false on eviction failure instead of growing.
+	Rehash RehashFunc
 }
 
 // Creates a brand new cuckoo table
@@ -82,16 +97,23 @@ func (t *Table) isInBucket(bucketIndex int, target *Entry) bool {
 // Returns true on success, false if not inserted
 // Even if false is returned, the underlying data structure might be different (e.g. rebuilt)
 func (t *Table) Insert(e *Entry) bool {
-	ok := t.tryInsertToBucket(e.Bucket1, e)
-	if ok {
+	if t.tryInsertToBucket(e.Bucket1, e) || t.tryInsertToBucket(e.Bucket2, e) {
+		t.size++
 		return true
 	}
-	ok = t.tryInsertToBucket(e.Bucket2, e)
+
+	homeless, ok := t.evictAndInsert(e)
 	if ok {
+		t.size++
 		return true
 	}
-	// @todo Evict
 
+	if t.Rehash == nil {
+		return false
+	}
+	t.grow()
+	homeless.Bucket1, homeless.Bucket2 = t.Rehash(homeless.Data, t.numBuckets)
+	return t.Insert(homeless)
 }
 
 // Tries to inserts `target` into specified bucket
@@ -117,17 +139,136 @@ func (t *Table) tryInsertToBucket(bucketIndex int, target *Entry) bool {
 	return false
 }
 
-func (t *Table) evictAndInsert(bucketIndex int, target *Entry) *Entry {
+// evictAndInsert displaces entries along a random walk to make room for `e`:
+// it swaps `e` into a random slot of one of its candidate buckets, then tries
+// to re-insert the entry it displaced into that entry's other bucket,
+// repeating for up to maxKicks displacements. Returns (nil, true) on success,
+// or the entry left without a home and false once maxKicks is exhausted.
+func (t *Table) evictAndInsert(e *Entry) (*Entry, bool) {
+	victim := e
+	bucketIndex := victim.Bucket1
+	if rand.Intn(2) == 1 {
+		bucketIndex = victim.Bucket2
+	}
+
+	for kick := 0; kick < maxKicks; kick++ {
+		bucket := t.buckets[bucketIndex]
+		slot := rand.Intn(t.depth)
+		evicted := bucket.entries[slot]
+
+		bucket.entries[slot] = victim
+		bucket.filled[slot] = true
+
+		altIndex := evicted.Bucket1
+		if altIndex == bucketIndex {
+			altIndex = evicted.Bucket2
+		}
+		if t.tryInsertToBucket(altIndex, evicted) {
+			return nil, true
+		}
+
+		victim = evicted
+		bucketIndex = altIndex
+	}
+
+	return victim, false
+}
+
+// grow doubles numBuckets (rounding up to the next power of two, so an
+// arbitrarily-sized initial table converges to power-of-two growth) and
+// rehashes every live entry into the new, empty table via Rehash.
+func (t *Table) grow() {
+	newNumBuckets := nextPowerOfTwo(t.numBuckets)
+	old := t.buckets
+
+	t.buckets = make([]*Bucket, newNumBuckets)
+	for i := 0; i < newNumBuckets; i++ {
+		t.buckets[i] = &Bucket{
+			entries: make([]*Entry, t.depth),
+			filled:  make([]bool, t.depth),
+		}
+	}
+	t.numBuckets = newNumBuckets
+	t.size = 0
+
+	for _, bucket := range old {
+		for i, filled := range bucket.filled {
+			if !filled {
+				continue
+			}
+			e := bucket.entries[i]
+			e.Bucket1, e.Bucket2 = t.Rehash(e.Data, t.numBuckets)
+			t.Insert(e)
+		}
+	}
+}
+
+func nextPowerOfTwo(n int) int {
+	p := 1
+	for p <= n {
+		p <<= 1
+	}
+	return p
+}
+
+// Len returns the number of live entries in the table.
+func (t *Table) Len() int {
+	return t.size
+}
+
+// LoadFactor returns the fraction of slots (numBuckets*depth) currently occupied.
+func (t *Table) LoadFactor() float64 {
+	capacity := t.numBuckets * t.depth
+	if capacity == 0 {
+		return 0
+	}
+	return float64(t.size) / float64(capacity)
+}
+
+// Iterate calls f once for every live entry in the table, in no particular
+// order, stopping early if f returns false. It lets a caller walk all live
+// entries without reaching into Table's internals; replica.Server's message
+// GC (server/replica/server.go, ApplyLayout) ended up using the separate,
+// more compact cuckootable.Filter instead, so Iterate's current users are
+// Table's own tests.
+func (t *Table) Iterate(f func(*Entry) bool) {
+	for _, bucket := range t.buckets {
+		for i, filled := range bucket.filled {
+			if !filled {
+				continue
+			}
+			if !f(bucket.entries[i]) {
+				return
+			}
+		}
+	}
 }
 
 // Removes the entry from the cuckoo table
-func (t *Table) Remove(target *Entry) {
-	t.removeFromBucket(target.Bucket1, target)
-	t.removeFromBucket(target.Bucket2, target)
+// Returns true if anything was removed
+func (t *Table) Remove(target *Entry) bool {
+	removed1 := t.removeFromBucket(target.Bucket1, target)
+	removed2 := t.removeFromBucket(target.Bucket2, target)
+	return removed1 || removed2
 }
 
 // Removes all copies of `target` from the specified bucket
 // `target` matches against any entry where all fields match
+// Returns true if anything was removed
 func (t *Table) removeFromBucket(bucketIndex int, target *Entry) bool {
+	if bucketIndex >= t.numBuckets {
+		return false
+	}
 
+	bucket := t.buckets[bucketIndex]
+	removed := false
+	for i := 0; i < t.depth; i++ {
+		if bucket.filled[i] && bucket.entries[i].Equals(target) {
+			bucket.filled[i] = false
+			bucket.entries[i] = nil
+			removed = true
+			t.size--
+		}
+	}
+	return removed
 }
@@ -0,0 +1,192 @@
+package cuckootable
+
+import "testing"
+
+type intData int
+
+func (d intData) Equals(other Comparable) bool {
+	o, ok := other.(intData)
+	return ok && d == o
+}
+
+// hashToBuckets is a toy, deterministic stand-in for whatever hash a real
+// caller would use to place intData into a table of numBuckets buckets.
+func hashToBuckets(v int, numBuckets int) (int, int) {
+	h1 := v % numBuckets
+	if h1 < 0 {
+		h1 += numBuckets
+	}
+	h2 := (v*2654435761 + 1) % numBuckets
+	if h2 < 0 {
+		h2 += numBuckets
+	}
+	return h1, h2
+}
+
+func newEntry(v int, numBuckets int) *Entry {
+	b1, b2 := hashToBuckets(v, numBuckets)
+	return &Entry{Bucket1: b1, Bucket2: b2, Data: intData(v)}
+}
+
+func TestInsertAndContains(t *testing.T) {
+	table := NewTable(8, 2)
+	e := newEntry(42, 8)
+	if !table.Insert(e) {
+		t.Fatalf("Insert failed")
+	}
+	if !table.Contains(e) {
+		t.Fatalf("Contains should find the inserted entry")
+	}
+	if table.Len() != 1 {
+		t.Fatalf("Len() = %d, want 1", table.Len())
+	}
+}
+
+// TestInsertTriggersEviction fills both of a new entry's candidate buckets
+// (depth 1), leaving room only in the residents' *other* candidate buckets
+// (2 for a, 3 for b), so Insert must displace one of those residents via
+// evictAndInsert to make room.
+func TestInsertTriggersEviction(t *testing.T) {
+	table := NewTable(4, 1)
+	a := &Entry{Bucket1: 0, Bucket2: 2, Data: intData(1)}
+	b := &Entry{Bucket1: 1, Bucket2: 3, Data: intData(2)}
+	if !table.Insert(a) || !table.Insert(b) {
+		t.Fatalf("priming inserts failed")
+	}
+
+	c := &Entry{Bucket1: 0, Bucket2: 1, Data: intData(3)}
+	if !table.Insert(c) {
+		t.Fatalf("Insert should succeed by evicting a or b into its free alternate bucket")
+	}
+
+	for _, e := range []*Entry{a, b, c} {
+		if !table.Contains(e) {
+			t.Fatalf("Contains(%v) failed after eviction", e.Data)
+		}
+	}
+	if table.Len() != 3 {
+		t.Fatalf("Len() = %d, want 3", table.Len())
+	}
+}
+
+// TestInsertFailsWithoutRehash fills a table to its true capacity (no spare
+// slot anywhere), so the next Insert cannot succeed by eviction alone and,
+// with no Rehash hook set, must report failure rather than grow.
+func TestInsertFailsWithoutRehash(t *testing.T) {
+	table := NewTable(2, 1)
+	if !table.Insert(newEntry(0, 2)) || !table.Insert(newEntry(1, 2)) {
+		t.Fatalf("priming inserts failed")
+	}
+	if table.Insert(newEntry(99, 2)) {
+		t.Fatalf("Insert should fail once full with no Rehash hook set")
+	}
+}
+
+// TestInsertGrowsWhenFull checks that, with Rehash set, Insert keeps
+// succeeding past the point a fixed-size table would give up, by growing
+// and rehashing existing entries.
+func TestInsertGrowsWhenFull(t *testing.T) {
+	const startBuckets = 2
+	table := NewTable(startBuckets, 1)
+	table.Rehash = func(data Comparable, newNumBuckets int) (int, int) {
+		return hashToBuckets(int(data.(intData)), newNumBuckets)
+	}
+
+	entries := make([]*Entry, 0, 8)
+	for i := 0; i < 8; i++ {
+		e := newEntry(i, startBuckets)
+		if !table.Insert(e) {
+			t.Fatalf("Insert(%d) failed even with Rehash set", i)
+		}
+		entries = append(entries, e)
+	}
+
+	for _, e := range entries {
+		if !table.Contains(e) {
+			t.Fatalf("Contains(%v) failed after grow", e.Data)
+		}
+	}
+	if table.Len() != len(entries) {
+		t.Fatalf("Len() = %d, want %d", table.Len(), len(entries))
+	}
+	if table.numBuckets <= startBuckets {
+		t.Fatalf("table should have grown past %d buckets, has %d", startBuckets, table.numBuckets)
+	}
+}
+
+// TestInsertRehashesEntryThatForcedGrowth checks the entry that triggers
+// eviction failure (and so forces grow()) is itself stored with coordinates
+// valid in the grown table, not stale pre-growth ones. It looks each entry
+// up via a freshly-hashed *Entry rather than reusing the pointer Insert was
+// given, since a real caller recomputes Bucket1/Bucket2 at lookup time and
+// would be fooled by stale coordinates that Table never catches internally.
+// Each entry is hashed against the table's current size at insert time, as
+// a real caller would do.
+func TestInsertRehashesEntryThatForcedGrowth(t *testing.T) {
+	const startBuckets = 2
+	table := NewTable(startBuckets, 1)
+	table.Rehash = func(data Comparable, newNumBuckets int) (int, int) {
+		return hashToBuckets(int(data.(intData)), newNumBuckets)
+	}
+
+	values := make([]int, 0, 8)
+	for i := 0; i < 8; i++ {
+		if !table.Insert(newEntry(i, table.numBuckets)) {
+			t.Fatalf("Insert(%d) failed even with Rehash set", i)
+		}
+		values = append(values, i)
+	}
+
+	for _, v := range values {
+		lookup := newEntry(v, table.numBuckets)
+		if !table.Contains(lookup) {
+			t.Fatalf("Contains(%d) failed against freshly-hashed coordinates after grow", v)
+		}
+	}
+}
+
+func TestRemove(t *testing.T) {
+	table := NewTable(8, 2)
+	e := newEntry(7, 8)
+	table.Insert(e)
+
+	if !table.Remove(e) {
+		t.Fatalf("Remove should report that it removed something")
+	}
+	if table.Contains(e) {
+		t.Fatalf("entry should be gone after Remove")
+	}
+	if table.Len() != 0 {
+		t.Fatalf("Len() = %d, want 0", table.Len())
+	}
+	if table.Remove(e) {
+		t.Fatalf("Remove of an already-removed entry should report false")
+	}
+}
+
+func TestIterateAndLoadFactor(t *testing.T) {
+	table := NewTable(4, 2)
+	want := make(map[int]bool, 4)
+	for i := 0; i < 4; i++ {
+		table.Insert(newEntry(i, 4))
+		want[i] = true
+	}
+
+	got := make(map[int]bool, 4)
+	table.Iterate(func(e *Entry) bool {
+		got[int(e.Data.(intData))] = true
+		return true
+	})
+	if len(got) != len(want) {
+		t.Fatalf("Iterate visited %d entries, want %d", len(got), len(want))
+	}
+	for k := range want {
+		if !got[k] {
+			t.Fatalf("Iterate missed entry %d", k)
+		}
+	}
+
+	if lf := table.LoadFactor(); lf <= 0 || lf > 1 {
+		t.Fatalf("LoadFactor() = %v, want in (0, 1]", lf)
+	}
+}
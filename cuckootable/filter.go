@@ -0,0 +1,177 @@
+package cuckootable
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"io"
+	"math/rand"
+)
+
+// FingerprintBits selects the width of fingerprints stored in a Filter.
+type FingerprintBits uint
+
+const (
+	// Fingerprint8 stores 8-bit fingerprints: smaller, higher false positive rate.
+	Fingerprint8 FingerprintBits = 8
+	// Fingerprint16 stores 16-bit fingerprints: larger, lower false positive rate.
+	Fingerprint16 FingerprintBits = 16
+)
+
+// filterMaxKicks bounds how many displacements Add will attempt before
+// declaring eviction a failure, mirroring Table's maxKicks.
+const filterMaxKicks = 500
+
+// Filter is a cuckoo filter: a compact approximate-membership index that
+// stores small fingerprints instead of full entries, trading a bounded false
+// positive rate for much lower memory than Table. Unlike Table, Filter never
+// grows itself; callers size it for their expected membership up front.
+type Filter struct {
+	numBuckets int
+	depth      int
+	fpMask     uint16
+	buckets    [][]uint16 // 0 means empty; fingerprints are nudged away from 0
+}
+
+// NewFilter creates an empty cuckoo filter sized for numBuckets buckets
+// (rounded up to the next power of two, so the i1/i2 XOR relation is
+// reversible) of depth fingerprint slots each, storing fpBits-wide
+// fingerprints.
+func NewFilter(numBuckets int, depth int, fpBits FingerprintBits) *Filter {
+	f := &Filter{
+		numBuckets: nextPowerOfTwo(numBuckets - 1),
+		depth:      depth,
+		fpMask:     uint16(1)<<uint(fpBits) - 1,
+	}
+	f.buckets = make([][]uint16, f.numBuckets)
+	for i := range f.buckets {
+		f.buckets[i] = make([]uint16, depth)
+	}
+	return f
+}
+
+func fnvHash(data []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(data)
+	return h.Sum64()
+}
+
+// fingerprint derives a non-zero fingerprint for data; 0 is reserved to mean
+// "empty slot", so a hash landing on it is nudged to 1.
+func (f *Filter) fingerprint(data []byte) uint16 {
+	fp := uint16(fnvHash(data)) & f.fpMask
+	if fp == 0 {
+		fp = 1
+	}
+	return fp
+}
+
+// altIndex returns the other candidate bucket for a fingerprint, given one of
+// its buckets: i2 = i1 XOR hash(fingerprint), which is its own inverse.
+func (f *Filter) altIndex(index int, fp uint16) int {
+	var fpBytes [2]byte
+	binary.BigEndian.PutUint16(fpBytes[:], fp)
+	return index ^ int(fnvHash(fpBytes[:])%uint64(f.numBuckets))
+}
+
+func (f *Filter) indices(data []byte) (i1 int, i2 int, fp uint16) {
+	i1 = int(fnvHash(data) % uint64(f.numBuckets))
+	fp = f.fingerprint(data)
+	i2 = f.altIndex(i1, fp)
+	return
+}
+
+// Add inserts data's fingerprint, evicting via a bounded random walk (as in
+// Table.evictAndInsert) if both candidate buckets are full. Returns false if
+// filterMaxKicks is exhausted without finding room; the fingerprint left
+// without a slot at that point, which may belong to an earlier Add, is
+// dropped.
+func (f *Filter) Add(data []byte) bool {
+	i1, i2, fp := f.indices(data)
+	if f.tryInsert(i1, fp) || f.tryInsert(i2, fp) {
+		return true
+	}
+
+	index := i1
+	if rand.Intn(2) == 1 {
+		index = i2
+	}
+	for kick := 0; kick < filterMaxKicks; kick++ {
+		slot := rand.Intn(f.depth)
+		fp, f.buckets[index][slot] = f.buckets[index][slot], fp
+		index = f.altIndex(index, fp)
+		if f.tryInsert(index, fp) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *Filter) tryInsert(index int, fp uint16) bool {
+	for i, slot := range f.buckets[index] {
+		if slot == 0 {
+			f.buckets[index][i] = fp
+			return true
+		}
+	}
+	return false
+}
+
+// Contains reports whether data's fingerprint is present in either of its
+// candidate buckets. Like any cuckoo filter, false positives are possible;
+// false negatives are not (absent a Delete of a colliding fingerprint or an
+// Add that returned false).
+func (f *Filter) Contains(data []byte) bool {
+	i1, i2, fp := f.indices(data)
+	return f.hasFingerprint(i1, fp) || f.hasFingerprint(i2, fp)
+}
+
+func (f *Filter) hasFingerprint(index int, fp uint16) bool {
+	for _, slot := range f.buckets[index] {
+		if slot == fp {
+			return true
+		}
+	}
+	return false
+}
+
+// Delete removes one copy of data's fingerprint, if present, from either
+// candidate bucket. Returns whether anything was removed.
+func (f *Filter) Delete(data []byte) bool {
+	i1, i2, fp := f.indices(data)
+	if f.deleteFingerprint(i1, fp) {
+		return true
+	}
+	return f.deleteFingerprint(i2, fp)
+}
+
+func (f *Filter) deleteFingerprint(index int, fp uint16) bool {
+	for i, slot := range f.buckets[index] {
+		if slot == fp {
+			f.buckets[index][i] = 0
+			return true
+		}
+	}
+	return false
+}
+
+// SerializeTo writes the filter's parameters followed by its raw fingerprint
+// table to w. replica.Server currently rebuilds its GC filter from scratch on
+// every ApplyLayout rather than persisting or transferring one, so this is
+// provided as a standalone utility for callers that do want to ship or cache
+// a filter out of band.
+func (f *Filter) SerializeTo(w io.Writer) error {
+	header := []uint64{uint64(f.numBuckets), uint64(f.depth), uint64(f.fpMask)}
+	for _, v := range header {
+		if err := binary.Write(w, binary.BigEndian, v); err != nil {
+			return err
+		}
+	}
+	for _, bucket := range f.buckets {
+		for _, fp := range bucket {
+			if err := binary.Write(w, binary.BigEndian, fp); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package cuckootable
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func keyBytes(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
+
+func TestFilterAddAndContains(t *testing.T) {
+	f := NewFilter(8, 2, Fingerprint16)
+	k := keyBytes(42)
+	if !f.Add(k) {
+		t.Fatalf("Add failed")
+	}
+	if !f.Contains(k) {
+		t.Fatalf("Contains should find the added key")
+	}
+}
+
+// TestFilterAddTriggersEviction fills a depth-1 filter past the point where
+// both of a new key's candidate buckets are full, forcing Add to displace
+// a resident via the bounded random walk before every key is found again.
+// With 4 buckets, key 0 lands in bucket 1 (alternate 2) and key 6 in bucket
+// 3 (alternate 0); key 2's candidates are buckets 3 and 1, both then full.
+func TestFilterAddTriggersEviction(t *testing.T) {
+	f := NewFilter(4, 1, Fingerprint16)
+	keys := make([][]byte, 0, 3)
+	for _, i := range []uint64{0, 6, 2} {
+		k := keyBytes(i)
+		if !f.Add(k) {
+			t.Fatalf("Add(%d) failed", i)
+		}
+		keys = append(keys, k)
+	}
+
+	for i, k := range keys {
+		if !f.Contains(k) {
+			t.Fatalf("Contains(%d) failed after eviction", i)
+		}
+	}
+}
+
+func TestFilterDelete(t *testing.T) {
+	f := NewFilter(8, 2, Fingerprint16)
+	k := keyBytes(7)
+	f.Add(k)
+
+	if !f.Delete(k) {
+		t.Fatalf("Delete should report that it removed something")
+	}
+	if f.Contains(k) {
+		t.Fatalf("key should be gone after Delete")
+	}
+	if f.Delete(k) {
+		t.Fatalf("Delete of an already-removed key should report false")
+	}
+}
+
+// TestFilterSerializeToRoundTrip checks that SerializeTo writes exactly the
+// 3-word header followed by the raw bucket table, so a reader that knows the
+// filter's shape can reconstruct it byte-for-byte.
+func TestFilterSerializeToRoundTrip(t *testing.T) {
+	f := NewFilter(4, 2, Fingerprint16)
+	f.Add(keyBytes(1))
+	f.Add(keyBytes(2))
+
+	var buf bytes.Buffer
+	if err := f.SerializeTo(&buf); err != nil {
+		t.Fatalf("SerializeTo failed: %v", err)
+	}
+
+	wantLen := 3*8 + f.numBuckets*f.depth*2
+	if buf.Len() != wantLen {
+		t.Fatalf("SerializeTo wrote %d bytes, want %d", buf.Len(), wantLen)
+	}
+
+	r := bytes.NewReader(buf.Bytes())
+	var numBuckets, depth, fpMask uint64
+	binary.Read(r, binary.BigEndian, &numBuckets)
+	binary.Read(r, binary.BigEndian, &depth)
+	binary.Read(r, binary.BigEndian, &fpMask)
+	if int(numBuckets) != f.numBuckets || int(depth) != f.depth || uint16(fpMask) != f.fpMask {
+		t.Fatalf("header = (%d, %d, %d), want (%d, %d, %d)", numBuckets, depth, fpMask, f.numBuckets, f.depth, f.fpMask)
+	}
+
+	for i, bucket := range f.buckets {
+		for j, want := range bucket {
+			var got uint16
+			if err := binary.Read(r, binary.BigEndian, &got); err != nil {
+				t.Fatalf("reading fingerprint[%d][%d]: %v", i, j, err)
+			}
+			if got != want {
+				t.Fatalf("fingerprint[%d][%d] = %d, want %d", i, j, got, want)
+			}
+		}
+	}
+}
@@ -4,7 +4,10 @@ import (
 	"math/rand"
 	"testing"
 
+	"github.com/privacylab/talek/common"
 	"github.com/privacylab/talek/pir"
+	protoreplica "github.com/privacylab/talek/protocol/replica"
+	"github.com/privacylab/talek/server/replica"
 )
 
 type FatalInterface interface {
@@ -128,4 +131,84 @@ func HelperBenchmarkShardRead(b *testing.B, shard pir.Shard, batchSize int) {
 		}
 	}
 	b.StopTimer()
+}
+
+// HelperBenchmarkReplicaRead drives replica.Server.Read end-to-end across
+// every shard in s with a batch of batchSize requests, each selecting one
+// uniformly random bucket per shard. s must already have its shards
+// populated (e.g. via InstallLayout over BenchNumMessages messages) by the
+// caller; this is meant to be benchmarked against HelperBenchmarkShardRead
+// with BenchBatchSize to tune the batching/worker-pool heuristics in
+// replica.Server.Read against the single-shard baseline.
+func HelperBenchmarkReplicaRead(b *testing.B, s *replica.Server, numShards int, numBucketsPerShard int, batchSize int) {
+	reqLength := numBucketsPerShard / 8
+	if numBucketsPerShard%8 != 0 {
+		reqLength++
+	}
+	perRequestLength := reqLength * numShards
+
+	readArgs := make([]common.PirArgs, batchSize)
+	for i := range readArgs {
+		vec := make([]byte, perRequestLength)
+		for shardIdx := 0; shardIdx < numShards; shardIdx++ {
+			bucket := rand.Intn(numBucketsPerShard)
+			vec[shardIdx*reqLength+bucket/8] |= byte(1) << uint(bucket%8)
+		}
+		readArgs[i] = common.PirArgs{RequestVector: vec}
+	}
+	args := &protoreplica.ReadArgs{ReadArgs: readArgs}
+	var reply protoreplica.ReadReply
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := s.Read(args, &reply); err != nil {
+			b.Fatalf("Read error: %v\n", err)
+		}
+		if reply.Err != "" {
+			b.Fatalf("Read returned error: %v\n", reply.Err)
+		}
+	}
+	b.StopTimer()
+}
+
+// BenchmarkReplicaRead builds a real replica.Server sharded so that
+// NumShardsPerGroup*NumBucketsPerShard*BucketDepth == BenchNumMessages,
+// fills it via Write, installs an identity layout, and drives
+// replica.Server.Read at BenchBatchSize end-to-end via
+// HelperBenchmarkReplicaRead, so the batching/worker-pool heuristics in
+// replica.Server.Read can be compared directly against
+// HelperBenchmarkShardRead's single-shard baseline.
+func BenchmarkReplicaRead(b *testing.B) {
+	const numShards = 4
+	numBucketsPerShard := uint64(BenchNumMessages) / (numShards * BenchDepth)
+
+	config := common.Config{
+		NumShardsPerGroup:  numShards,
+		NumBucketsPerShard: numBucketsPerShard,
+		NumBuckets:         numBucketsPerShard * numShards,
+		BucketDepth:        BenchDepth,
+		DataSize:           BenchMessageSize,
+	}
+
+	s, err := replica.NewServer("bench-replica", "", false, config, 0, "Matrix")
+	if err != nil {
+		b.Fatalf("replica.NewServer: %v\n", err)
+	}
+	defer s.Close()
+
+	layoutTable := make([]uint64, BenchNumMessages)
+	for id := uint64(0); id < uint64(BenchNumMessages); id++ {
+		var writeReply common.WriteReply
+		writeArgs := &common.WriteArgs{ID: id, Data: GenerateData(BenchMessageSize)}
+		if err := s.Write(writeArgs, &writeReply); err != nil || writeReply.Err != "" {
+			b.Fatalf("Write(%d): err=%v reply.Err=%v\n", id, err, writeReply.Err)
+		}
+		layoutTable[id] = id
+	}
+
+	if err := s.InstallLayout(0, layoutTable); err != nil {
+		b.Fatalf("InstallLayout: %v\n", err)
+	}
+
+	HelperBenchmarkReplicaRead(b, s, numShards, int(numBucketsPerShard), BenchBatchSize)
 }
\ No newline at end of file
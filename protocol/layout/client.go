@@ -0,0 +1,143 @@
+package layout
+
+import (
+	"errors"
+	"fmt"
+	"net/rpc"
+	"sync"
+)
+
+// Client talks to a layout service that may be a single server or, behind
+// the same RPC surface, a protocol/layout/raft cluster spread across several
+// endpoints. GetLayout tries each configured endpoint in turn and follows a
+// LeaderHint to the current leader, so a replica keeps working as long as
+// any minority of the configured endpoints is down.
+type Client struct {
+	name string
+
+	mu    sync.Mutex
+	addrs []string
+	conns map[string]*rpc.Client
+}
+
+// NewClient creates a Client against addrs (in priority order). addrs may be
+// empty; GetLayout then fails until the replica learns real endpoints (e.g.
+// via a Notify RPC) and is reconstructed with them.
+func NewClient(name string, addrs []string) *Client {
+	return &Client{
+		name:  name,
+		addrs: append([]string{}, addrs...),
+		conns: make(map[string]*rpc.Client),
+	}
+}
+
+// Close closes any RPC connections this Client has opened.
+func (c *Client) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, conn := range c.conns {
+		conn.Close()
+	}
+	c.conns = make(map[string]*rpc.Client)
+}
+
+func (c *Client) dial(addr string) (*rpc.Client, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if conn, ok := c.conns[addr]; ok {
+		return conn, nil
+	}
+	conn, err := rpc.DialHTTP("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	c.conns[addr] = conn
+	return conn, nil
+}
+
+// promote moves addr to the front of c.addrs (inserting it if new), so
+// future calls try the current leader first.
+func (c *Client) promote(addr string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	filtered := make([]string, 0, len(c.addrs)+1)
+	filtered = append(filtered, addr)
+	for _, a := range c.addrs {
+		if a != addr {
+			filtered = append(filtered, a)
+		}
+	}
+	c.addrs = filtered
+}
+
+func (c *Client) endpoints() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string{}, c.addrs...)
+}
+
+// GetLayout calls the GetLayout RPC, trying each configured endpoint in
+// order and following any LeaderHint the server returns (e.g. a raft-backed
+// server pointing at the current leader) until one endpoint answers
+// successfully or every known endpoint has been tried.
+func (c *Client) GetLayout(args *GetLayoutArgs, reply *GetLayoutReply) error {
+	addrs := c.endpoints()
+	if len(addrs) == 0 {
+		return fmt.Errorf("layout.Client(%v): no endpoints configured", c.name)
+	}
+
+	var lastErr error
+	tried := make(map[string]bool, len(addrs)+1)
+	addr := addrs[0]
+	for len(tried) <= len(addrs) {
+		if tried[addr] {
+			break
+		}
+		tried[addr] = true
+
+		conn, err := c.dial(addr)
+		if err != nil {
+			lastErr = err
+			addr = nextEndpoint(addrs, addr)
+			continue
+		}
+
+		// Reset reply so fields from an earlier endpoint's answer (gob leaves
+		// zero-valued fields undecoded) do not leak into this one.
+		*reply = GetLayoutReply{}
+		err = conn.Call("Server.GetLayout", args, reply)
+		if err != nil {
+			lastErr = err
+			addr = nextEndpoint(addrs, addr)
+			continue
+		}
+
+		if reply.LeaderHint != "" && reply.LeaderHint != addr && !tried[reply.LeaderHint] {
+			c.promote(reply.LeaderHint)
+			addr = reply.LeaderHint
+			continue
+		}
+		if reply.Err == ErrorNotLeader {
+			lastErr = errors.New(ErrorNotLeader)
+			addr = nextEndpoint(addrs, addr)
+			continue
+		}
+
+		return nil
+	}
+
+	if lastErr == nil {
+		lastErr = errors.New("exhausted all known endpoints")
+	}
+	return fmt.Errorf("layout.Client(%v): %v", c.name, lastErr)
+}
+
+// nextEndpoint returns the endpoint after addr in addrs, wrapping around.
+func nextEndpoint(addrs []string, addr string) string {
+	for i, a := range addrs {
+		if a == addr {
+			return addrs[(i+1)%len(addrs)]
+		}
+	}
+	return addrs[0]
+}
@@ -0,0 +1,123 @@
+package layout
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/rpc"
+	"strings"
+	"testing"
+)
+
+// fakeServer answers the Server.GetLayout RPC with whatever reply/err is
+// configured, so tests can script a layout backend's behavior without a
+// real raft cluster.
+type fakeServer struct {
+	reply GetLayoutReply
+	err   error
+}
+
+func (s *fakeServer) GetLayout(args *GetLayoutArgs, reply *GetLayoutReply) error {
+	*reply = s.reply
+	return s.err
+}
+
+// startFakeServer serves backend over a net/rpc-over-HTTP listener on
+// 127.0.0.1 and returns its address. Each test gets its own listener and
+// mux, so servers don't collide on the default http.ServeMux the way
+// rpc.HandleHTTP would.
+func startFakeServer(t *testing.T, backend *fakeServer) string {
+	t.Helper()
+	rpcServer := rpc.NewServer()
+	if err := rpcServer.RegisterName("Server", backend); err != nil {
+		t.Fatalf("RegisterName: %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle(rpc.DefaultRPCPath, rpcServer)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	go http.Serve(ln, mux)
+	t.Cleanup(func() { ln.Close() })
+	return ln.Addr().String()
+}
+
+// closedAddr reserves and immediately releases a loopback port, so dialing
+// it fails the way a down or unreachable endpoint would.
+func closedAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	return addr
+}
+
+func TestClientGetLayoutFailsOverOnDialError(t *testing.T) {
+	bad := closedAddr(t)
+	good := startFakeServer(t, &fakeServer{reply: GetLayoutReply{SnapshotID: 1, Layout: []uint64{1, 2, 3}}})
+
+	c := NewClient("test", []string{bad, good})
+	defer c.Close()
+
+	var reply GetLayoutReply
+	if err := c.GetLayout(&GetLayoutArgs{NumSplit: 1}, &reply); err != nil {
+		t.Fatalf("GetLayout failed to fail over to the good endpoint: %v", err)
+	}
+	if reply.SnapshotID != 1 {
+		t.Fatalf("SnapshotID = %v, want 1", reply.SnapshotID)
+	}
+}
+
+func TestClientGetLayoutFailsOverOnCallError(t *testing.T) {
+	failing := startFakeServer(t, &fakeServer{err: errors.New("backend exploded")})
+	good := startFakeServer(t, &fakeServer{reply: GetLayoutReply{SnapshotID: 2}})
+
+	c := NewClient("test", []string{failing, good})
+	defer c.Close()
+
+	var reply GetLayoutReply
+	if err := c.GetLayout(&GetLayoutArgs{NumSplit: 1}, &reply); err != nil {
+		t.Fatalf("GetLayout failed to fail over past a call error: %v", err)
+	}
+	if reply.SnapshotID != 2 {
+		t.Fatalf("SnapshotID = %v, want 2", reply.SnapshotID)
+	}
+}
+
+func TestClientGetLayoutFollowsLeaderHintOutsideAddrs(t *testing.T) {
+	leader := startFakeServer(t, &fakeServer{reply: GetLayoutReply{SnapshotID: 3}})
+	follower := startFakeServer(t, &fakeServer{reply: GetLayoutReply{Err: ErrorNotLeader, LeaderHint: leader}})
+
+	c := NewClient("test", []string{follower})
+	defer c.Close()
+
+	var reply GetLayoutReply
+	if err := c.GetLayout(&GetLayoutArgs{NumSplit: 1}, &reply); err != nil {
+		t.Fatalf("GetLayout failed to follow LeaderHint: %v", err)
+	}
+	if reply.SnapshotID != 3 {
+		t.Fatalf("SnapshotID = %v, want 3 (from the hinted leader)", reply.SnapshotID)
+	}
+}
+
+func TestClientGetLayoutGivesUpOnceEveryEndpointTried(t *testing.T) {
+	a := startFakeServer(t, &fakeServer{err: errors.New("a down")})
+	b := startFakeServer(t, &fakeServer{err: errors.New("b down")})
+
+	c := NewClient("test", []string{a, b})
+	defer c.Close()
+
+	var reply GetLayoutReply
+	err := c.GetLayout(&GetLayoutArgs{NumSplit: 1}, &reply)
+	if err == nil {
+		t.Fatalf("GetLayout should fail once every endpoint has been tried")
+	}
+	if !strings.Contains(err.Error(), "test") {
+		t.Fatalf("error = %v, want it to identify the client by name", err)
+	}
+}
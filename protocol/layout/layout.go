@@ -0,0 +1,44 @@
+// Package layout defines the wire protocol between a replica and the
+// frontend(s) that assign it a layout: which message ID occupies each slot
+// of each bucket, as of a given snapshotID. The backend serving this RPC may
+// be a single frontend or, via protocol/layout/raft, a Raft-replicated
+// cluster of frontends; Client speaks to either transparently.
+package layout
+
+// Error sentinels returned in GetLayoutReply.Err.
+const (
+	// ErrorInvalidSnapshotID is returned when args.SnapshotID does not match
+	// what the server currently has committed; reply.SnapshotID carries the
+	// server's actual value so the caller can retry with it.
+	ErrorInvalidSnapshotID = "invalid snapshotID"
+	// ErrorInvalidIndex is returned when args.Index is out of range for args.NumSplit.
+	ErrorInvalidIndex = "invalid index"
+	// ErrorInvalidNumSplit is returned when args.NumSplit does not evenly divide the layout.
+	ErrorInvalidNumSplit = "invalid numSplit"
+	// ErrorNotLeader is returned by a replicated backend (protocol/layout/raft)
+	// that cannot currently vouch for its applied index as up to date with the
+	// cluster (it is not the leader, or the leader's lease could not be
+	// confirmed); reply.LeaderHint carries where to retry instead.
+	ErrorNotLeader = "not leader"
+)
+
+// GetLayoutArgs requests the Index-th of NumSplit even slices of the layout
+// committed as of SnapshotID.
+type GetLayoutArgs struct {
+	SnapshotID uint64
+	Index      uint64
+	NumSplit   uint64
+}
+
+// GetLayoutReply carries the requested layout slice on success, or an error
+// sentinel in Err (plus, for ErrorInvalidSnapshotID, the server's actual
+// SnapshotID). LeaderHint is set by a replicated (protocol/layout/raft)
+// backend to the address of the node it believes is the current leader,
+// whether or not this call succeeded, so Client can stick with the leader on
+// subsequent calls without the caller needing to know about Raft.
+type GetLayoutReply struct {
+	Err        string
+	SnapshotID uint64
+	Layout     []uint64
+	LeaderHint string
+}
@@ -0,0 +1,180 @@
+// Package raft replicates the layout/snapshot-assignment service (normally a
+// single protocol/layout server) as a Raft state machine across N frontends,
+// so the replicas it serves are not left with a single point of failure.
+// It implements the same wire RPCs as the single-server protocol/layout
+// backend, so a layout.Client can talk to either transparently.
+package raft
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+
+	"github.com/privacylab/talek/protocol/layout"
+)
+
+// ErrNotLeader is returned by Propose when called against a follower; the
+// caller should retry against LeaderHint.
+var ErrNotLeader = errors.New("raft: not the leader")
+
+// applyTimeout bounds how long Propose waits for a layout change to commit.
+const applyTimeout = 5 * time.Second
+
+// Entry is a single committed layout assignment: a snapshotID and the full
+// bucket -> message-ID layout table valid as of that snapshot. Raft log
+// entries carry a json-encoded Entry, and an FSM snapshot is just the most
+// recently applied Entry.
+type Entry struct {
+	SnapshotID uint64
+	Layout     []uint64
+}
+
+// FSM is the Raft state machine backing the layout service: Apply commits a
+// new Entry, and reads are served from whatever Entry was last applied.
+type FSM struct {
+	mu      sync.RWMutex
+	current Entry
+}
+
+// NewFSM creates an empty FSM, starting at SnapshotID 0 with an empty layout.
+func NewFSM() *FSM {
+	return &FSM{}
+}
+
+// Apply implements raft.FSM.
+func (f *FSM) Apply(log *raft.Log) interface{} {
+	var entry Entry
+	if err := json.Unmarshal(log.Data, &entry); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	f.current = entry
+	f.mu.Unlock()
+	return nil
+}
+
+// Current returns the most recently committed Entry.
+func (f *FSM) Current() Entry {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.current
+}
+
+// Snapshot implements raft.FSM: the FSM snapshot is simply the current layout table.
+func (f *FSM) Snapshot() (raft.FSMSnapshot, error) {
+	return &fsmSnapshot{entry: f.Current()}, nil
+}
+
+// Restore implements raft.FSM, replacing the current layout with the snapshotted one.
+func (f *FSM) Restore(rc io.ReadCloser) error {
+	defer rc.Close()
+	var entry Entry
+	if err := json.NewDecoder(rc).Decode(&entry); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	f.current = entry
+	f.mu.Unlock()
+	return nil
+}
+
+type fsmSnapshot struct {
+	entry Entry
+}
+
+func (s *fsmSnapshot) Persist(sink raft.SnapshotSink) error {
+	if err := json.NewEncoder(sink).Encode(s.entry); err != nil {
+		sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+func (s *fsmSnapshot) Release() {}
+
+// Server answers protocol/layout RPCs (GetLayout) from a node participating
+// in a raft.Raft cluster running FSM, and commits new layouts via Propose.
+// It is meant to sit behind the same layout.Client interface as the
+// single-server backend: GetLayout only serves its locally applied Entry
+// when it can confirm, via raft.Raft.VerifyLeader, that it is still the
+// leader and so cannot be behind a newer leader's commits; any other node
+// returns ErrorNotLeader with a LeaderHint so the client fails over to the
+// leader instead of reading stale data. Propose also requires the leader,
+// but checks only this node's raft state (no VerifyLeader) and returns a bare
+// ErrNotLeader otherwise; LeaderHint reports where to retry.
+type Server struct {
+	raft *raft.Raft
+	fsm  *FSM
+}
+
+// NewServer wraps an already-bootstrapped *raft.Raft (configured by the
+// caller with the usual hashicorp/raft Transport/LogStore/SnapshotStore)
+// running fsm.
+func NewServer(r *raft.Raft, fsm *FSM) *Server {
+	return &Server{raft: r, fsm: fsm}
+}
+
+// Propose commits a new (snapshotID, layoutTable) tuple through Raft. It
+// must be called on the leader.
+func (s *Server) Propose(snapshotID uint64, layoutTable []uint64) error {
+	if s.raft.State() != raft.Leader {
+		return ErrNotLeader
+	}
+	data, err := json.Marshal(Entry{SnapshotID: snapshotID, Layout: layoutTable})
+	if err != nil {
+		return err
+	}
+	return s.raft.Apply(data, applyTimeout).Error()
+}
+
+// LeaderHint returns the address of the current Raft leader as seen by this
+// node, for a layout.Client to fail over a stale read or a Propose call.
+func (s *Server) LeaderHint() string {
+	addr, _ := s.raft.LeaderWithID()
+	return string(addr)
+}
+
+// GetLayout implements the protocol/layout wire RPC: it serves the Index-th
+// of NumSplit even slices of the committed layout for SnapshotID, following
+// the same error sentinels as the single-server backend, plus a LeaderHint
+// so a layout.Client polling a non-leader (or one that lost the leader)
+// knows where to retry. Before serving, it confirms this node's leadership
+// lease with raft.Raft.VerifyLeader, so a node that has been partitioned off
+// or deposed without yet noticing never answers with stale data.
+func (s *Server) GetLayout(args *layout.GetLayoutArgs, reply *layout.GetLayoutReply) error {
+	reply.LeaderHint = s.LeaderHint()
+
+	if s.raft.State() != raft.Leader {
+		reply.Err = layout.ErrorNotLeader
+		return nil
+	}
+	if err := s.raft.VerifyLeader().Error(); err != nil {
+		reply.Err = layout.ErrorNotLeader
+		return nil
+	}
+
+	entry := s.fsm.Current()
+	if args.SnapshotID != entry.SnapshotID {
+		reply.Err = layout.ErrorInvalidSnapshotID
+		reply.SnapshotID = entry.SnapshotID
+		return nil
+	}
+	if args.NumSplit == 0 || uint64(len(entry.Layout))%args.NumSplit != 0 {
+		reply.Err = layout.ErrorInvalidNumSplit
+		return nil
+	}
+	splitSize := uint64(len(entry.Layout)) / args.NumSplit
+	if args.Index >= args.NumSplit {
+		reply.Err = layout.ErrorInvalidIndex
+		return nil
+	}
+
+	reply.Err = ""
+	reply.SnapshotID = entry.SnapshotID
+	reply.Layout = entry.Layout[args.Index*splitSize : (args.Index+1)*splitSize]
+	return nil
+}
@@ -0,0 +1,89 @@
+package raft
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/hashicorp/raft"
+)
+
+// fakeSnapshotSink is a minimal raft.SnapshotSink that buffers Persist's
+// output for inspection instead of writing to a real snapshot store.
+type fakeSnapshotSink struct {
+	bytes.Buffer
+	canceled bool
+}
+
+func (s *fakeSnapshotSink) ID() string    { return "fake" }
+func (s *fakeSnapshotSink) Cancel() error { s.canceled = true; return nil }
+func (s *fakeSnapshotSink) Close() error  { return nil }
+
+func applyEntry(t *testing.T, f *FSM, entry Entry) {
+	t.Helper()
+	data, err := json.Marshal(entry)
+	if err != nil {
+		t.Fatalf("json.Marshal: %v", err)
+	}
+	if err, _ := f.Apply(&raft.Log{Data: data}).(error); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+}
+
+func TestFSMApplyAndCurrent(t *testing.T) {
+	f := NewFSM()
+	if got := f.Current(); got.SnapshotID != 0 || len(got.Layout) != 0 {
+		t.Fatalf("Current() on a new FSM = %+v, want zero value", got)
+	}
+
+	want := Entry{SnapshotID: 1, Layout: []uint64{1, 2, 3}}
+	applyEntry(t, f, want)
+
+	if got := f.Current(); got.SnapshotID != want.SnapshotID || !equalLayout(got.Layout, want.Layout) {
+		t.Fatalf("Current() = %+v, want %+v", got, want)
+	}
+}
+
+func TestFSMApplyRejectsGarbage(t *testing.T) {
+	f := NewFSM()
+	result := f.Apply(&raft.Log{Data: []byte("not json")})
+	if _, ok := result.(error); !ok {
+		t.Fatalf("Apply(garbage) = %v, want an error", result)
+	}
+}
+
+func TestFSMSnapshotAndRestore(t *testing.T) {
+	f := NewFSM()
+	want := Entry{SnapshotID: 7, Layout: []uint64{4, 5, 6}}
+	applyEntry(t, f, want)
+
+	snap, err := f.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	sink := &fakeSnapshotSink{}
+	if err := snap.Persist(sink); err != nil {
+		t.Fatalf("Persist: %v", err)
+	}
+
+	restored := NewFSM()
+	if err := restored.Restore(io.NopCloser(bytes.NewReader(sink.Bytes()))); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if got := restored.Current(); got.SnapshotID != want.SnapshotID || !equalLayout(got.Layout, want.Layout) {
+		t.Fatalf("Current() after Restore = %+v, want %+v", got, want)
+	}
+}
+
+func equalLayout(a, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
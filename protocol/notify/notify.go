@@ -0,0 +1,16 @@
+// Package notify defines the RPC a frontend uses to tell a replica that a
+// new layout is ready to be fetched.
+package notify
+
+// Args tells a replica that SnapshotID is ready, and gives it the layout
+// service endpoint(s) to fetch it from (plural since the layout service may
+// be a protocol/layout/raft cluster rather than a single frontend).
+type Args struct {
+	Addrs      []string
+	SnapshotID uint64
+}
+
+// Reply acknowledges a Notify call.
+type Reply struct {
+	Err string
+}
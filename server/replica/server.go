@@ -1,15 +1,21 @@
 package replica
 
 import (
+	"encoding/binary"
+	"fmt"
+	"runtime"
+	"strings"
 	"sync"
 
 	"github.com/privacylab/talek/common"
+	"github.com/privacylab/talek/cuckootable"
 	"github.com/privacylab/talek/pir/pirinterface"
 	"github.com/privacylab/talek/protocol/layout"
 	"github.com/privacylab/talek/protocol/notify"
 	"github.com/privacylab/talek/protocol/replica"
 	"github.com/privacylab/talek/server"
 	"golang.org/x/net/trace"
+	"golang.org/x/time/rate"
 )
 
 // Server is the main logic for replicas
@@ -27,12 +33,17 @@ type Server struct {
 	// Thread-safe (organized by lock scope)
 	lock         *sync.RWMutex
 	snapshotID   uint64
-	layoutAddr   string
+	layout       []uint64
+	layoutAddrs  []string
 	layoutClient *layout.Client
 	shards       []pirinterface.Shard
 
 	msgLock  *sync.Mutex
 	messages map[uint64]*common.WriteArgs
+
+	// snapshotLimiter bounds the rate of the Snapshot RPC so a bulk transfer
+	// to a catching-up replica cannot starve this replica's own Read.
+	snapshotLimiter *rate.Limiter
 }
 
 // NewServer creates a new replica server
@@ -51,13 +62,15 @@ func NewServer(name string, addr string, listenRPC bool, config common.Config, g
 
 	s.lock = &sync.RWMutex{}
 	s.snapshotID = 0
-	s.layoutAddr = ""
-	s.layoutClient = layout.NewClient(s.name, "")
+	s.layoutAddrs = nil
+	s.layoutClient = layout.NewClient(s.name, nil)
 	s.shards = make([]pirinterface.Shard, s.config.NumShardsPerGroup)
 
 	s.msgLock = &sync.Mutex{}
 	s.messages = make(map[uint64]*common.WriteArgs)
 
+	s.snapshotLimiter = rate.NewLimiter(rate.Limit(snapshotRateLimit), snapshotRateLimit)
+
 	s.log.Info.Printf("replica.NewServer(%v) success\n", name)
 	return s, nil
 }
@@ -86,7 +99,7 @@ func (s *Server) Notify(args *notify.Args, reply *notify.Reply) error {
 	defer tr.Finish()
 	//s.lock.RLock()
 
-	go s.GetLayout(args.Addr, args.SnapshotID)
+	go s.GetLayout(args.Addrs, args.SnapshotID)
 	reply.Err = ""
 
 	//s.lock.RUnlock()
@@ -119,21 +132,94 @@ func (s *Server) Read(args *replica.ReadArgs, reply *replica.ReadReply) error {
 	s.lock.RLock()
 
 	if s.snapshotID < args.SnapshotID {
-		go s.GetLayout(s.layoutAddr, args.SnapshotID)
+		go s.GetLayout(s.layoutAddrs, args.SnapshotID)
 		reply.Err = "Need updated layout. Try again later."
 		s.lock.RUnlock()
 		return nil
 	}
 
-	// @todo
-	//shardIdx :=
-	//shard := s.shards[shardIdx]
-	reply.Err = ""
+	// Each request vector spans this whole replica group's buckets, laid out
+	// shard-by-shard exactly as ApplyLayout built s.shards, so it splits into
+	// NumShardsPerGroup equal, contiguous per-shard chunks.
+	reqLength := int((s.config.NumBucketsPerShard + 7) / 8)
+	perRequestLength := reqLength * len(s.shards)
+	for i, req := range args.ReadArgs {
+		if len(req.RequestVector) != perRequestLength {
+			reply.Err = fmt.Sprintf("invalid RequestVector length at index %d: got %d, want %d", i, len(req.RequestVector), perRequestLength)
+			s.lock.RUnlock()
+			return nil
+		}
+	}
+
+	numRequests := len(args.ReadArgs)
+	shardReqs := make([][]byte, len(s.shards))
+	for shardIdx := range s.shards {
+		reqs := make([]byte, reqLength*numRequests)
+		for i, req := range args.ReadArgs {
+			copy(reqs[i*reqLength:(i+1)*reqLength], req.RequestVector[shardIdx*reqLength:(shardIdx+1)*reqLength])
+		}
+		shardReqs[shardIdx] = reqs
+	}
+
+	shardData, shardErrs := s.readShards(shardReqs, reqLength)
+
+	bucketSize := int(s.config.BucketDepth * s.config.DataSize)
+	data := make([]byte, numRequests*bucketSize)
+	var errs []string
+	for shardIdx, resp := range shardData {
+		if shardErrs[shardIdx] != nil {
+			errs = append(errs, fmt.Sprintf("shard %d: %v", shardIdx, shardErrs[shardIdx]))
+			continue
+		}
+		for i := range data {
+			data[i] ^= resp[i]
+		}
+	}
+
+	if len(errs) > 0 {
+		reply.Err = strings.Join(errs, "; ")
+	} else {
+		reply.Err = ""
+		reply.Data = data
+	}
 
 	s.lock.RUnlock()
 	return nil
 }
 
+// readShards dispatches one batched Read per shard concurrently, across a
+// worker pool bounded to runtime.NumCPU() (the GPU device count, when the
+// shards are CL-backed, would bound it instead, but we don't have visibility
+// into that here), and collects each shard's response and error by index.
+func (s *Server) readShards(shardReqs [][]byte, reqLength int) ([][]byte, []error) {
+	data := make([][]byte, len(s.shards))
+	errs := make([]error, len(s.shards))
+
+	numWorkers := runtime.NumCPU()
+	if numWorkers > len(s.shards) {
+		numWorkers = len(s.shards)
+	}
+
+	jobs := make(chan int, len(s.shards))
+	var wg sync.WaitGroup
+	for w := 0; w < numWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for shardIdx := range jobs {
+				data[shardIdx], errs[shardIdx] = s.shards[shardIdx].Read(shardReqs[shardIdx], reqLength)
+			}
+		}()
+	}
+	for shardIdx := range s.shards {
+		jobs <- shardIdx
+	}
+	close(jobs)
+	wg.Wait()
+
+	return data, errs
+}
+
 /**********************************
  * PUBLIC LOCAL METHODS (threadsafe)
  **********************************/
@@ -151,12 +237,16 @@ func (s *Server) Close() {
 	//s.lock.Unlock()
 }
 
-// SetLayoutAddr will set the address and RPC client towards the server from which we get layouts
-// Note: This will do nothing if addr is the same as we've seen before
-func (s *Server) SetLayoutAddr(addr string) {
-	// Check if layoutAddr has changed
+// SetLayoutAddrs will set the addresses and RPC client towards the frontends
+// from which we get layouts. layout.Client itself handles retry/failover
+// across addrs (following the current leader's hint when the backend is
+// protocol/layout/raft), so a replica keeps working as long as any minority
+// of frontends is down.
+// Note: This will do nothing if addrs is the same as we've seen before
+func (s *Server) SetLayoutAddrs(addrs []string) {
+	// Check if layoutAddrs has changed
 	s.lock.RLock()
-	if s.layoutAddr == addr {
+	if sameAddrs(s.layoutAddrs, addrs) {
 		s.lock.RUnlock()
 		return
 	}
@@ -167,21 +257,31 @@ func (s *Server) SetLayoutAddr(addr string) {
 	if s.layoutClient != nil {
 		s.layoutClient.Close()
 	}
-	s.layoutAddr = addr
-	s.layoutClient = layout.NewClient(s.name, addr)
-	s.log.Info.Printf("%v.SetLayoutAddr(%v): success\n", s.name, addr)
+	s.layoutAddrs = addrs
+	s.layoutClient = layout.NewClient(s.name, addrs)
+	s.log.Info.Printf("%v.SetLayoutAddrs(%v): success\n", s.name, addrs)
 	s.lock.Unlock()
 }
 
+func sameAddrs(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
 // GetLayout will fetch the layout for a snapshotID and apply it locally
-func (s *Server) GetLayout(addr string, snapshotID uint64) {
+func (s *Server) GetLayout(addrs []string, snapshotID uint64) {
 	tr := trace.New("Replica", "GetLayout")
 	defer tr.Finish()
 
-	// Try to establish an RPC client to server. Does nothing if addr is seen before
-	s.SetLayoutAddr(addr)
-	// Locked region
-	s.lock.Lock()
+	// Try to establish an RPC client to the frontends. Does nothing if addrs is seen before
+	s.SetLayoutAddrs(addrs)
 
 	// Do RPC
 	layoutSize := s.config.NumBucketsPerShard * s.config.NumShardsPerGroup
@@ -195,32 +295,46 @@ func (s *Server) GetLayout(addr string, snapshotID uint64) {
 
 	// Error handling
 	if err != nil {
-		s.log.Error.Printf("%v.GetLayout(%v, %v) returns error: %v, giving up.\n", s.name, addr, snapshotID, err)
-		s.lock.Unlock()
+		s.log.Error.Printf("%v.GetLayout(%v, %v) returns error: %v, giving up.\n", s.name, addrs, snapshotID, err)
 		return
 	} else if reply.Err == layout.ErrorInvalidSnapshotID {
-		s.log.Error.Printf("%v.GetLayout(%v, %v) failed with invalid SnapshotID=%v, should be %v. Trying again.\n", s.name, addr, snapshotID, args.SnapshotID, reply.SnapshotID)
-		go s.GetLayout(addr, reply.SnapshotID)
-		s.lock.Unlock()
+		s.log.Error.Printf("%v.GetLayout(%v, %v) failed with invalid SnapshotID=%v, should be %v. Trying again.\n", s.name, addrs, snapshotID, args.SnapshotID, reply.SnapshotID)
+		go s.GetLayout(addrs, reply.SnapshotID)
 		return
 	} else if reply.Err == layout.ErrorInvalidIndex {
-		s.log.Error.Printf("%v.GetLayout(%v, %v) failed with invalid Index=%v, giving up.\n", s.name, addr, snapshotID, args.Index)
-		s.lock.Unlock()
+		s.log.Error.Printf("%v.GetLayout(%v, %v) failed with invalid Index=%v, giving up.\n", s.name, addrs, snapshotID, args.Index)
 		return
 	} else if reply.Err == layout.ErrorInvalidNumSplit {
-		s.log.Error.Printf("%v.GetLayout(%v, %v) failed with invalid NumSplit=%v, giving up.\n", s.name, addr, snapshotID, args.NumSplit)
-		s.lock.Unlock()
+		s.log.Error.Printf("%v.GetLayout(%v, %v) failed with invalid NumSplit=%v, giving up.\n", s.name, addrs, snapshotID, args.NumSplit)
+		return
+	} else if reply.Err == layout.ErrorNotLeader {
+		s.log.Error.Printf("%v.GetLayout(%v, %v) found no confirmed leader (hint=%v), giving up.\n", s.name, addrs, snapshotID, reply.LeaderHint)
 		return
 	}
 
 	// Only set on success
-	shards := s.ApplyLayout(s.config, s.pirBacking, reply.Layout)
-	if shards != nil {
-		s.snapshotID = snapshotID
-		s.shards = shards
+	if err := s.InstallLayout(snapshotID, reply.Layout); err != nil {
+		s.log.Error.Printf("%v.GetLayout(%v, %v): %v\n", s.name, addrs, snapshotID, err)
+	}
+}
+
+// InstallLayout runs ApplyLayout and, on success, publishes the resulting
+// shards under s.lock. It is the same final step GetLayout takes once it has
+// a layout in hand from a layout.Client; callers that already know the
+// layout out-of-band (e.g. RestoreSnapshot, or a benchmark driving
+// replica.Server.Read without a live layout service) can use it directly.
+func (s *Server) InstallLayout(snapshotID uint64, layoutTable []uint64) error {
+	shards := s.ApplyLayout(s.config, s.pirBacking, layoutTable)
+	if shards == nil {
+		return fmt.Errorf("InstallLayout: ApplyLayout failed for snapshotID %v", snapshotID)
 	}
 
+	s.lock.Lock()
+	s.snapshotID = snapshotID
+	s.shards = shards
+	s.layout = layoutTable
 	s.lock.Unlock()
+	return nil
 }
 
 // ApplyLayout takes in a new layout and generates Shards from previously stored bank of messages
@@ -241,7 +355,7 @@ func (s *Server) ApplyLayout(config common.Config, pirBacking string, layout []u
 			msg, ok := s.messages[id]
 			if !ok {
 				s.log.Error.Printf("ApplyLayout() failed. Missing message ID=%v, giving up.\n", id)
-				s.lock.Unlock()
+				s.msgLock.Unlock()
 				return nil
 			}
 			// msg.Data is the correct size as per assertion in Write()
@@ -250,8 +364,41 @@ func (s *Server) ApplyLayout(config common.Config, pirBacking string, layout []u
 		shards[i] = NewShard(bucketSize, data, pirBacking)
 	}
 
-	// Garbage collect old messages from s.messages
-	// @todo
+	// Garbage collect old messages from s.messages. A cuckoo filter lets us
+	// test membership in `layout` in O(1) instead of an O(N*M) scan, so a
+	// replica can hold many snapshots' worth of pending messages without
+	// unbounded memory growth.
+	//
+	// `layout` has one entry per storage slot, so the same ID can appear in
+	// it many times (once per slot it occupies); dedupe before adding so we
+	// only ever consume one filter slot per distinct ID. If the filter still
+	// fails to Add an ID (it's sized for len(uniqueIDs), so this should only
+	// happen under adversarial hash collisions), we can't trust Contains for
+	// that ID, so we abort the whole GC pass rather than risk deleting a
+	// message the current layout still references.
+	uniqueIDs := make(map[uint64]bool, len(layout))
+	for _, id := range layout {
+		uniqueIDs[id] = true
+	}
+	filter := cuckootable.NewFilter(len(uniqueIDs), 4, cuckootable.Fingerprint16)
+	idBytes := make([]byte, 8)
+	gcOK := true
+	for id := range uniqueIDs {
+		binary.BigEndian.PutUint64(idBytes, id)
+		if !filter.Add(idBytes) {
+			s.log.Error.Printf("ApplyLayout() GC: filter.Add failed for ID=%v, skipping GC this cycle.\n", id)
+			gcOK = false
+			break
+		}
+	}
+	if gcOK {
+		for id := range s.messages {
+			binary.BigEndian.PutUint64(idBytes, id)
+			if !filter.Contains(idBytes) {
+				delete(s.messages, id)
+			}
+		}
+	}
 
 	s.msgLock.Unlock()
 	return shards
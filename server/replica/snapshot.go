@@ -0,0 +1,246 @@
+package replica
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/privacylab/talek/common"
+)
+
+// SnapshotPath is the HTTP path a replica's NetworkRPC mux should route to
+// ServeSnapshotHTTP so other replicas can bootstrap from it.
+const SnapshotPath = "/snapshot"
+
+// snapshotRateLimit bounds how many frames/sec Snapshot will emit, so a large
+// transfer to a catching-up replica cannot starve this replica's own Read.
+const snapshotRateLimit = 256
+
+// restoreMaxRetries and restoreBackoff bound RestoreSnapshot's retry loop so
+// a persistently broken source cannot make it spin forever hammering src.
+const (
+	restoreMaxRetries = 10
+	restoreBackoff    = 500 * time.Millisecond
+)
+
+// snapshotFrame is the unit written to a Snapshot stream. The first frame
+// (Msg == nil) carries the header; every frame after it carries one message,
+// in ascending ID order.
+type snapshotFrame struct {
+	SnapshotID uint64
+	Layout     []uint64
+	ID         uint64
+	Msg        *common.WriteArgs
+}
+
+// Snapshot streams a consistent dump of s.messages, plus the current
+// snapshotID and layout, as length-prefixed frames (mirroring the pattern of
+// etcd's Maintenance.Snapshot), in ascending message-ID order.
+//
+// afterID resumes a previously interrupted transfer: when hasAfterID is
+// true, only messages with ID > afterID (the last one the caller already
+// consumed) are sent, and the header frame is skipped. Keying resume off the
+// last ID actually delivered, rather than a position in s.messages, keeps a
+// resumed transfer correct even if Writes land on this replica in between:
+// a position-based offset would shift under concurrent Writes and could
+// skip or duplicate messages, exactly the condition a bootstrapping replica
+// runs under. The caller must Close the returned stream.
+func (s *Server) Snapshot(ctx context.Context, hasAfterID bool, afterID uint64) (io.ReadCloser, error) {
+	s.msgLock.Lock()
+	ids := make([]uint64, 0, len(s.messages))
+	messages := make(map[uint64]*common.WriteArgs, len(s.messages))
+	for id, msg := range s.messages {
+		ids = append(ids, id)
+		messages[id] = msg
+	}
+	s.msgLock.Unlock()
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	if hasAfterID {
+		ids = idsAfter(ids, afterID)
+	}
+
+	s.lock.RLock()
+	snapshotID := s.snapshotID
+	layout := s.layout
+	s.lock.RUnlock()
+
+	pr, pw := io.Pipe()
+	go func() {
+		bw := bufio.NewWriter(pw)
+		if !hasAfterID {
+			if err := writeSnapshotFrame(bw, &snapshotFrame{SnapshotID: snapshotID, Layout: layout}); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		for _, id := range ids {
+			if err := s.snapshotLimiter.Wait(ctx); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			if err := writeSnapshotFrame(bw, &snapshotFrame{ID: id, Msg: messages[id]}); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		if err := bw.Flush(); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		pw.Close()
+	}()
+
+	return pr, nil
+}
+
+// idsAfter returns the suffix of the ascending-sorted ids strictly greater than afterID.
+func idsAfter(ids []uint64, afterID uint64) []uint64 {
+	i := sort.Search(len(ids), func(i int) bool { return ids[i] > afterID })
+	return ids[i:]
+}
+
+// ServeSnapshotHTTP exposes Snapshot as a chunked HTTP response so a
+// catching-up replica can pull it with RestoreSnapshot. It should be
+// registered at SnapshotPath on the same mux as the replica's NetworkRPC.
+func (s *Server) ServeSnapshotHTTP(w http.ResponseWriter, r *http.Request) {
+	var hasAfterID bool
+	var afterID uint64
+	if v := r.URL.Query().Get("afterId"); v != "" {
+		if _, err := fmt.Sscanf(v, "%d", &afterID); err != nil {
+			http.Error(w, "invalid afterId", http.StatusBadRequest)
+			return
+		}
+		hasAfterID = true
+	}
+
+	stream, err := s.Snapshot(r.Context(), hasAfterID, afterID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer stream.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if _, err := io.Copy(w, stream); err != nil {
+		s.log.Error.Printf("%v.ServeSnapshotHTTP: %v\n", s.name, err)
+	}
+}
+
+// RestoreSnapshot pulls a Snapshot stream from src (another replica's
+// NetworkRPC address) and repopulates s.messages under s.msgLock, resuming
+// from the last message ID it successfully consumed if the connection drops
+// mid-transfer. It gives up after restoreMaxRetries attempts (backing off
+// restoreBackoff between each) or when ctx is done, so a persistently broken
+// src cannot make this spin forever. Once the bank has landed, it applies
+// the streamed layout so s.shards reflects a consistent view.
+func (s *Server) RestoreSnapshot(ctx context.Context, src string) error {
+	var snapshotID uint64
+	var layout []uint64
+	var hasLastID bool
+	var lastID uint64
+
+	for attempt := 0; ; attempt++ {
+		url := fmt.Sprintf("http://%s%s", src, SnapshotPath)
+		if hasLastID {
+			url = fmt.Sprintf("%s?afterId=%d", url, lastID)
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return fmt.Errorf("RestoreSnapshot(%v): %v", src, err)
+		}
+
+		readErr := func() error {
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				body, _ := io.ReadAll(resp.Body)
+				return fmt.Errorf("%v: %s", resp.Status, body)
+			}
+			return s.drainSnapshotFrames(resp.Body, !hasLastID, &snapshotID, &layout, &hasLastID, &lastID)
+		}()
+
+		if readErr == nil {
+			break
+		}
+		if attempt+1 >= restoreMaxRetries {
+			return fmt.Errorf("RestoreSnapshot(%v): giving up after %d attempts: %v", src, attempt+1, readErr)
+		}
+		s.log.Error.Printf("%v.RestoreSnapshot(%v): %v, retrying from last ID %v (hasLastID=%v)\n", s.name, src, readErr, lastID, hasLastID)
+
+		select {
+		case <-time.After(restoreBackoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return s.InstallLayout(snapshotID, layout)
+}
+
+// drainSnapshotFrames reads frames from r until EOF or error. Message frames
+// are stored into s.messages and advance *lastID/*hasLastID, so a retry
+// resumes after the last one actually consumed; the header frame is only
+// captured when wantHeader is true (i.e. this is the first chunk of the
+// transfer, not a resume).
+func (s *Server) drainSnapshotFrames(r io.Reader, wantHeader bool, snapshotID *uint64, layout *[]uint64, hasLastID *bool, lastID *uint64) error {
+	for {
+		frame, err := readSnapshotFrame(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if frame.Msg == nil {
+			if wantHeader {
+				*snapshotID = frame.SnapshotID
+				*layout = frame.Layout
+			}
+			continue
+		}
+		s.msgLock.Lock()
+		s.messages[frame.ID] = frame.Msg
+		s.msgLock.Unlock()
+		*lastID = frame.ID
+		*hasLastID = true
+	}
+}
+
+func writeSnapshotFrame(w io.Writer, frame *snapshotFrame) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(frame); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.BigEndian, uint32(buf.Len())); err != nil {
+		return err
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func readSnapshotFrame(r io.Reader) (*snapshotFrame, error) {
+	var length uint32
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	frame := &snapshotFrame{}
+	if err := gob.NewDecoder(bytes.NewReader(buf)).Decode(frame); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
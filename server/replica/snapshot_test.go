@@ -0,0 +1,229 @@
+package replica
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/privacylab/talek/common"
+)
+
+// newTestReplica builds a small replica.Server (1 shard, 4 single-slot
+// buckets) suitable for exercising Snapshot/RestoreSnapshot without pulling
+// in a real PIR backend beyond the in-memory "Matrix" one.
+func newTestReplica(t *testing.T, name string) *Server {
+	t.Helper()
+	config := common.Config{
+		NumShardsPerGroup:  1,
+		NumBucketsPerShard: 4,
+		NumBuckets:         4,
+		BucketDepth:        1,
+		DataSize:           8,
+	}
+	s, err := NewServer(name, "", false, config, 0, "Matrix")
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	t.Cleanup(s.Close)
+	return s
+}
+
+func writeTestMessages(t *testing.T, s *Server, ids ...uint64) {
+	t.Helper()
+	for _, id := range ids {
+		data := bytes.Repeat([]byte{byte(id + 1)}, 8)
+		var reply common.WriteReply
+		if err := s.Write(&common.WriteArgs{ID: id, Data: data}, &reply); err != nil || reply.Err != "" {
+			t.Fatalf("Write(%d): err=%v reply.Err=%v", id, err, reply.Err)
+		}
+	}
+}
+
+func TestSnapshotRestoreSnapshotRoundTrip(t *testing.T) {
+	src := newTestReplica(t, "src")
+	writeTestMessages(t, src, 0, 1, 2, 3)
+	if err := src.InstallLayout(5, []uint64{0, 1, 2, 3}); err != nil {
+		t.Fatalf("InstallLayout: %v", err)
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(src.ServeSnapshotHTTP))
+	defer ts.Close()
+
+	dst := newTestReplica(t, "dst")
+	if err := dst.RestoreSnapshot(context.Background(), ts.Listener.Addr().String()); err != nil {
+		t.Fatalf("RestoreSnapshot: %v", err)
+	}
+
+	dst.lock.RLock()
+	gotSnapshotID, gotLayout := dst.snapshotID, dst.layout
+	dst.lock.RUnlock()
+	if gotSnapshotID != 5 || !reflect.DeepEqual(gotLayout, []uint64{0, 1, 2, 3}) {
+		t.Fatalf("dst snapshotID/layout = (%v, %v), want (5, [0 1 2 3])", gotSnapshotID, gotLayout)
+	}
+
+	src.msgLock.Lock()
+	wantMessages := src.messages
+	src.msgLock.Unlock()
+	dst.msgLock.Lock()
+	defer dst.msgLock.Unlock()
+	if len(dst.messages) != len(wantMessages) {
+		t.Fatalf("dst has %d messages, want %d", len(dst.messages), len(wantMessages))
+	}
+	for id, want := range wantMessages {
+		got, ok := dst.messages[id]
+		if !ok {
+			t.Fatalf("dst missing message %d", id)
+		}
+		if !bytes.Equal(got.Data, want.Data) {
+			t.Fatalf("dst message %d = %v, want %v", id, got.Data, want.Data)
+		}
+	}
+}
+
+// afterIDRecordingHandler wraps a Snapshot HTTP handler, recording the
+// afterId query param of every request it serves, so a test can assert a
+// retried RestoreSnapshot actually resumed instead of restarting the
+// transfer from scratch.
+type afterIDRecordingHandler struct {
+	h http.HandlerFunc
+
+	mu      sync.Mutex
+	seen    []string
+	failing bool
+	cutoff  int
+}
+
+func (h *afterIDRecordingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	h.seen = append(h.seen, r.URL.Query().Get("afterId"))
+	// Only the very first request is truncated; every retry after that is
+	// served in full, so the transfer can actually complete.
+	fail, cutoff := h.failing && len(h.seen) == 1, h.cutoff
+	h.failing = false
+	h.mu.Unlock()
+
+	if !fail {
+		h.h(w, r)
+		return
+	}
+	rec := httptest.NewRecorder()
+	h.h(rec, r)
+	body := rec.Body.Bytes()
+	if cutoff > len(body) {
+		cutoff = len(body)
+	}
+	w.WriteHeader(rec.Code)
+	w.Write(body[:cutoff])
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	// Hijack and close the underlying connection so the client observes a
+	// mid-stream error rather than a clean (if short) response.
+	if hj, ok := w.(http.Hijacker); ok {
+		if conn, _, err := hj.Hijack(); err == nil {
+			conn.Close()
+		}
+	}
+}
+
+// midFrameCutoff replays src's own Snapshot stream and returns a byte offset
+// 2 bytes into the frame after numFrames complete ones (header frame
+// included), so a test can truncate an HTTP response mid-frame: a cut at an
+// exact frame boundary would look like a clean, complete stream (a trailing
+// frame is indistinguishable from "no more frames"), so this deliberately
+// lands inside the next frame's 4-byte length prefix to force a genuine
+// decode error.
+func midFrameCutoff(t *testing.T, s *Server, numFrames int) int {
+	t.Helper()
+	stream, err := s.Snapshot(context.Background(), false, 0)
+	if err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	defer stream.Close()
+
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(stream); err != nil {
+		t.Fatalf("reading snapshot stream: %v", err)
+	}
+	full := buf.Bytes()
+
+	r := bytes.NewReader(full)
+	for i := 0; i < numFrames; i++ {
+		if _, err := readSnapshotFrame(r); err != nil {
+			t.Fatalf("readSnapshotFrame(%d): %v", i, err)
+		}
+	}
+	boundary := len(full) - r.Len()
+	cutoff := boundary + 2
+	if cutoff >= len(full) {
+		t.Fatalf("snapshot stream too short (%d bytes) to cut mid-frame after frame %d", len(full), numFrames)
+	}
+	return cutoff
+}
+
+func TestRestoreSnapshotResumesAfterMidStreamError(t *testing.T) {
+	src := newTestReplica(t, "src")
+	writeTestMessages(t, src, 0, 1, 2, 3)
+	if err := src.InstallLayout(9, []uint64{0, 1, 2, 3}); err != nil {
+		t.Fatalf("InstallLayout: %v", err)
+	}
+
+	// Cut the response right after the header frame and the first two
+	// message frames (ids 0 and 1), so the resumed request must ask for
+	// afterId=1 and pick up ids 2 and 3.
+	cutoff := midFrameCutoff(t, src, 3)
+	handler := &afterIDRecordingHandler{h: src.ServeSnapshotHTTP, failing: true, cutoff: cutoff}
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	dst := newTestReplica(t, "dst")
+	if err := dst.RestoreSnapshot(context.Background(), ts.Listener.Addr().String()); err != nil {
+		t.Fatalf("RestoreSnapshot: %v", err)
+	}
+
+	handler.mu.Lock()
+	seen := append([]string{}, handler.seen...)
+	handler.mu.Unlock()
+	if len(seen) < 2 {
+		t.Fatalf("expected at least 2 requests (initial + resume), got %v", seen)
+	}
+	if seen[0] != "" {
+		t.Fatalf("first request afterId = %q, want empty (fresh transfer)", seen[0])
+	}
+	if seen[1] != "1" {
+		t.Fatalf("resumed request afterId = %q, want \"1\"", seen[1])
+	}
+
+	dst.msgLock.Lock()
+	defer dst.msgLock.Unlock()
+	if len(dst.messages) != 4 {
+		t.Fatalf("dst has %d messages after resumed restore, want 4 (no duplicates/skips)", len(dst.messages))
+	}
+	for _, id := range []uint64{0, 1, 2, 3} {
+		if _, ok := dst.messages[id]; !ok {
+			t.Fatalf("dst missing message %d after resumed restore", id)
+		}
+	}
+}
+
+func TestRestoreSnapshotGivesUpAfterMaxRetries(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "permanently broken", http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	dst := newTestReplica(t, "dst")
+	err := dst.RestoreSnapshot(context.Background(), ts.Listener.Addr().String())
+	if err == nil {
+		t.Fatalf("RestoreSnapshot should give up against a permanently failing source")
+	}
+	want := fmt.Sprintf("giving up after %d attempts", restoreMaxRetries)
+	if !bytes.Contains([]byte(err.Error()), []byte(want)) {
+		t.Fatalf("error = %q, want it to contain %q", err.Error(), want)
+	}
+}